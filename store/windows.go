@@ -5,13 +5,40 @@ package store
 import (
 	"crypto/x509"
 	"fmt"
-	"os/exec"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
 
 	"github.com/adamdecaf/cert-manage/whitelist"
 )
 
 // Docs:
 // - https://msdn.microsoft.com/en-us/library/e78byta0(v=vs.110).aspx
+// - https://docs.microsoft.com/en-us/windows/desktop/api/wincrypt/nf-wincrypt-certopenstore
+// - https://docs.microsoft.com/en-us/windows/desktop/api/wincrypt/nf-wincrypt-certsavestore
+
+// storeNames lists every system store cert-manage inspects, matching what certmgr.exe
+// shows under "Certificates - Current User".
+var storeNames = []string{"My", "AuthRoot", "Root", "Trust", "CA", "Disallowed"}
+
+const sstExt = ".sst"
+
+// CertSaveStore isn't wrapped by golang.org/x/sys/windows, so call into crypt32.dll
+// directly for it.
+var (
+	modcrypt32        = syscall.NewLazyDLL("crypt32.dll")
+	procCertSaveStore = modcrypt32.NewProc("CertSaveStore")
+)
+
+const (
+	certStoreSaveAsStore    = 1 // CERT_STORE_SAVE_AS_STORE
+	certStoreSaveToFilename = 4 // CERT_STORE_SAVE_TO_FILENAME_W (2 is CERT_STORE_SAVE_TO_MEMORY)
+)
 
 type windowsStore struct{}
 
@@ -19,30 +46,213 @@ func platform() Store {
 	return windowsStore{}
 }
 
-func (s windowsStore) Backup() error {
-	return nil
+// openStore opens one of the named per-user system stores (My, Root, CA, ...), the
+// same set certmgr.exe shows.
+func openStore(name string) (windows.Handle, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	return windows.CertOpenStore(
+		windows.CERT_STORE_PROV_SYSTEM,
+		0,
+		0,
+		windows.CERT_SYSTEM_STORE_CURRENT_USER,
+		uintptr(unsafe.Pointer(namePtr)),
+	)
+}
+
+// derBytes copies the DER-encoded certificate out of a CertContext.
+func derBytes(ctx *windows.CertContext) []byte {
+	der := (*[1 << 20]byte)(unsafe.Pointer(ctx.EncodedCert))[:ctx.Length:ctx.Length]
+	out := make([]byte, len(der))
+	copy(out, der)
+	return out
 }
 
 func (s windowsStore) List() ([]*x509.Certificate, error) {
-	stores := []string{"My", "AuthRoot", "Root", "Trust", "CA", "Disallowed"}
-	for i := range stores {
-		fmt.Println(stores[i])
-		// b, err := exec.Command("cmd", "certmgr.exe", "/s", "-s", stores[i]).Output()
-		b, err := exec.Command("certmgr", "-s", stores[i]).Output()
+	var certs []*x509.Certificate
+
+	for _, name := range storeNames {
+		store, err := openStore(name)
 		if err != nil {
-			fmt.Println("Error: ", err)
+			return nil, fmt.Errorf("opening store %s: %v", name, err)
+		}
+
+		var ctx *windows.CertContext
+		for {
+			ctx, err = windows.CertEnumCertificatesInStore(store, ctx)
+			if err != nil || ctx == nil {
+				break
+			}
+			if parsed, err := x509.ParseCertificate(derBytes(ctx)); err == nil {
+				certs = append(certs, parsed)
+			}
+		}
+
+		if err := windows.CertCloseStore(store, 0); err != nil {
+			return nil, fmt.Errorf("closing store %s: %v", name, err)
 		}
-		fmt.Println(string(b))
 	}
 
-	return nil, nil
+	return certs, nil
 }
 
-// TODO(adam): impl
 func (s windowsStore) Remove(wh whitelist.Whitelist) error {
+	for _, name := range storeNames {
+		store, err := openStore(name)
+		if err != nil {
+			return fmt.Errorf("opening store %s: %v", name, err)
+		}
+
+		var ctx *windows.CertContext
+		for {
+			ctx, err = windows.CertEnumCertificatesInStore(store, ctx)
+			if err != nil || ctx == nil {
+				break
+			}
+
+			parsed, err := x509.ParseCertificate(derBytes(ctx))
+			if err != nil || wh.Matches(*parsed) {
+				continue // unparsable or whitelisted, leave it in place
+			}
+
+			// CertDeleteCertificateFromStore takes ownership of its argument and
+			// frees it, which would break the enumeration above, so operate on a
+			// duplicate of the context instead of ctx itself.
+			dup := windows.CertDuplicateCertificateContext(ctx)
+			if err := windows.CertDeleteCertificateFromStore(dup); err != nil {
+				windows.CertCloseStore(store, 0)
+				return fmt.Errorf("removing cert from %s: %v", name, err)
+			}
+		}
+
+		if err := windows.CertCloseStore(store, 0); err != nil {
+			return fmt.Errorf("closing store %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (s windowsStore) Backup() error {
+	dir, err := backupDir()
+	if err != nil {
+		return err
+	}
+
+	ts := time.Now().Format("20060102150405")
+	for _, name := range storeNames {
+		store, err := openStore(name)
+		if err != nil {
+			return fmt.Errorf("opening store %s: %v", name, err)
+		}
+
+		dst := filepath.Join(dir, name+"-"+ts+sstExt)
+		dstPtr, err := syscall.UTF16PtrFromString(dst)
+		if err != nil {
+			windows.CertCloseStore(store, 0)
+			return err
+		}
+
+		ret, _, callErr := procCertSaveStore.Call(
+			uintptr(store),
+			0,
+			uintptr(certStoreSaveAsStore),
+			uintptr(certStoreSaveToFilename),
+			uintptr(unsafe.Pointer(dstPtr)),
+			0,
+		)
+		closeErr := windows.CertCloseStore(store, 0)
+		if ret == 0 {
+			return fmt.Errorf("saving store %s to %s: %v", name, dst, callErr)
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
 	return nil
 }
 
 func (s windowsStore) Restore() error {
+	dir, err := backupDir()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range storeNames {
+		src, err := newestBackup(dir, name)
+		if err != nil {
+			return err
+		}
+		if src == "" {
+			continue // nothing backed up for this store yet
+		}
+
+		srcPtr, err := windows.UTF16PtrFromString(src)
+		if err != nil {
+			return err
+		}
+
+		fileStore, err := windows.CertOpenStore(
+			windows.CERT_STORE_PROV_FILENAME_W,
+			0,
+			0,
+			windows.CERT_STORE_OPEN_EXISTING_FLAG|windows.CERT_STORE_READONLY_FLAG,
+			uintptr(unsafe.Pointer(srcPtr)),
+		)
+		if err != nil {
+			return fmt.Errorf("opening backup %s: %v", src, err)
+		}
+
+		dst, err := openStore(name)
+		if err != nil {
+			windows.CertCloseStore(fileStore, 0)
+			return fmt.Errorf("opening store %s: %v", name, err)
+		}
+
+		var ctx *windows.CertContext
+		for {
+			ctx, err = windows.CertEnumCertificatesInStore(fileStore, ctx)
+			if err != nil || ctx == nil {
+				break
+			}
+			if err := windows.CertAddCertificateContextToStore(dst, ctx, windows.CERT_STORE_ADD_REPLACE_EXISTING, nil); err != nil {
+				windows.CertCloseStore(fileStore, 0)
+				windows.CertCloseStore(dst, 0)
+				return fmt.Errorf("restoring cert into %s: %v", name, err)
+			}
+		}
+
+		if err := windows.CertCloseStore(fileStore, 0); err != nil {
+			return err
+		}
+		if err := windows.CertCloseStore(dst, 0); err != nil {
+			return err
+		}
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// backupDir returns (creating if needed) the directory Backup writes .sst files
+// into and Restore reads them back from.
+func backupDir() (string, error) {
+	dir := filepath.Join(os.Getenv("APPDATA"), "cert-manage", "backups")
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// newestBackup returns the most recently written .sst file for the named store, or
+// an empty string if none exists yet.
+func newestBackup(dir, name string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, name+"-*"+sstExt))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", nil
+	}
+	sort.Strings(matches) // the `YYYYMMDDhhmmss` suffix sorts newest-last
+	return matches[len(matches)-1], nil
+}
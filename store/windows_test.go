@@ -0,0 +1,15 @@
+// +build windows
+
+package store
+
+import "testing"
+
+// TestCertStoreSaveToFilenameConstant guards against re-breaking Backup(): passing
+// the wrong dwSaveTo value to CertSaveStore is a silent type mismatch (the win32 API
+// reads pvSaveToPara as whatever struct dwSaveTo implies), not a compile error, so
+// there's nothing else that would catch a regression here.
+func TestCertStoreSaveToFilenameConstant(t *testing.T) {
+	if certStoreSaveToFilename != 4 {
+		t.Fatalf("certStoreSaveToFilename = %d, want 4 (CERT_STORE_SAVE_TO_FILENAME_W per wincrypt.h)", certStoreSaveToFilename)
+	}
+}
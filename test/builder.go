@@ -0,0 +1,67 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Builder drives the build-then-run lifecycle of a test environment (an `envs/*/Dockerfile`
+// plus the files copied alongside it). The default Builder shells out to a local docker
+// daemon; set TEST_BUILDER=buildah or TEST_BUILDER=kaniko to run the same environments
+// without one (see rootless.go).
+type Builder interface {
+	// Build turns the Dockerfile and supporting files found in dir into an image tagged tag.
+	Build(dir, tag string) error
+
+	// Run executes the image built for tag with the given `KEY=VALUE` environment variables
+	// set and returns its combined stdout/stderr, mirroring exec.Cmd.CombinedOutput.
+	Run(tag string, env []string) ([]byte, error)
+}
+
+// newBuilder picks a Builder according to the TEST_BUILDER env var. An empty or unrecognized
+// value falls back to docker, which is the only backend that's been supported historically.
+func newBuilder() Builder {
+	switch os.Getenv("TEST_BUILDER") {
+	case "buildah":
+		return newRootlessBuilder("buildah")
+	case "kaniko":
+		return newRootlessBuilder("kaniko")
+	default:
+		return dockerBuilder{}
+	}
+}
+
+// dockerBuilder is the original `docker build` / `docker run` backed Builder.
+type dockerBuilder struct{}
+
+func (dockerBuilder) Build(dir, tag string) error {
+	out, err := exec.Command("docker", "build", "-t", tag, dir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ERROR: err=%v\nOutput: %s", err, string(out))
+	}
+	return nil
+}
+
+func (dockerBuilder) Run(tag string, env []string) ([]byte, error) {
+	args := []string{"run"}
+	for i := range env {
+		args = append(args, "-e", env[i])
+	}
+	args = append(args, "-t", tag)
+	return exec.Command("docker", args...).CombinedOutput()
+}
@@ -17,7 +17,6 @@ package test
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -28,7 +27,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/tools/file"
 )
 
 var (
@@ -40,8 +39,9 @@ type dockerfile struct {
 	// Local fs path to the Dockerfile
 	base string
 
-	// Commands represents a series of commands to be ran in the image
-	commands []*Cmd
+	// directives are the RUN/COPY steps tests have appended, each of which becomes
+	// its own instruction (and so its own layer) in the built Dockerfile
+	directives []directive
 
 	// -t flag with build/run
 	tag string
@@ -54,6 +54,10 @@ type dockerfile struct {
 
 	// used for cert-manage init
 	sync.Once
+
+	// builder performs the actual build+run, backed by docker or a daemonless
+	// alternative selected via TEST_BUILDER
+	builder Builder
 }
 
 func Dockerfile(where string) *dockerfile {
@@ -67,13 +71,34 @@ func Dockerfile(where string) *dockerfile {
 	tag := fmt.Sprintf("cert-manage:%s-%d", filepath.Base(dir), now)
 
 	return &dockerfile{
-		base: where,
-		tag:  tag,
+		base:    where,
+		tag:     tag,
+		builder: newBuilder(),
 	}
 }
 
+// runScript appends a RUN directive executing script verbatim through the image's
+// shell. It's the building block Run, ShouldFail and ExitCode use, so each logical
+// assertion - however many statements it takes - becomes exactly one layer and a
+// failure in it is reported against that one RUN.
+func (d *dockerfile) runScript(script string) {
+	d.directives = append(d.directives, directive{instruction: "RUN", args: script})
+}
+
+// Run appends a RUN directive that executes cmd with args; it becomes its own
+// layer in the built image so a failure points at this exact step.
 func (d *dockerfile) Run(cmd string, args ...string) {
-	d.commands = append(d.commands, Command(cmd, args...))
+	full := append([]string{cmd}, args...)
+	d.runScript(strings.Join(full, " "))
+}
+
+// Copy appends a COPY directive bringing src (relative to the build context) in
+// at dst inside the image.
+func (d *dockerfile) Copy(src, dst string) {
+	d.directives = append(d.directives, directive{
+		instruction: "COPY",
+		args:        fmt.Sprintf("%s %s", src, dst),
+	})
 }
 
 func (d *dockerfile) RunSplit(stmt string) {
@@ -81,18 +106,17 @@ func (d *dockerfile) RunSplit(stmt string) {
 	d.Run(parts[0], parts[1:]...)
 }
 
+// ShouldFail asserts cmd fails, as a single RUN so the test log names this exact
+// assertion rather than a line number inside a larger script.
 func (d *dockerfile) ShouldFail(cmd string, args ...string) {
-	d.Run("set +e")
-	d.Run(cmd, args...)
-	d.Run("set -e")
+	full := append([]string{cmd}, args...)
+	d.runScript(fmt.Sprintf("! { %s; }", strings.Join(full, " ")))
 }
 
+// ExitCode asserts cmd exits with code, as a single RUN.
 func (d *dockerfile) ExitCode(code, cmd string, args ...string) {
-	d.Run("set +e")
-	d.Run(cmd, args...)
-	d.Run("code=$?")
-	d.Run("set -e")
-	d.Run("echo", "$code", "|", "grep", code)
+	full := append([]string{cmd}, args...)
+	d.runScript(fmt.Sprintf("set +e; %s; code=$?; set -e; echo $code | grep %s", strings.Join(full, " "), code))
 }
 
 // Equals returns an os/exec array of bash commands to check the previous command
@@ -160,63 +184,37 @@ func (d *dockerfile) build() {
 		}
 	}
 
-	dst, err := os.Create(filepath.Join(dir, "Dockerfile"))
+	// Parse envs/*/Dockerfile into its directives and append whatever RUN/COPY
+	// directives the test queued with Run/Copy/ShouldFail/etc, so every one of
+	// them lands as its own instruction (and so its own cacheable layer) rather
+	// than a line inside one big shell script.
+	directives, err := parseDockerfile(d.base)
 	if err != nil {
-		d.err = fmt.Errorf("tmp Dockerfile create err=%v", err)
+		d.err = fmt.Errorf("parsing %s: %v", d.base, err)
 		return
 	}
-	defer os.Remove(dst.Name())
+	directives = append(directives, d.directives...)
 
-	src, err := os.Open(d.base)
+	dst, err := os.Create(filepath.Join(dir, "Dockerfile"))
 	if err != nil {
-		d.err = fmt.Errorf("tmpfile open err=%v", err)
-		return
-	}
-	if _, err := io.Copy(dst, src); err != nil {
-		d.err = fmt.Errorf("src->dst copy err=%v", err)
-		return
-	}
-	if err := src.Close(); err != nil {
-		d.err = fmt.Errorf("src close err=%v", err)
-		return
-	}
-	// Force all writes into our Dockerfile
-	if err := dst.Sync(); err != nil {
-		d.err = fmt.Errorf("dst fsync err=%v", err)
+		d.err = fmt.Errorf("tmp Dockerfile create err=%v", err)
 		return
 	}
+	defer os.Remove(dst.Name())
 
-	// Add all commands to a script copied Dockerfile
-	script, err := os.Create(filepath.Join(dir, "script.sh"))
-	if err != nil {
-		d.err = err
-		return
-	}
-	defer os.Remove(script.Name())
-	_, err = script.WriteString(`#!/bin/sh
-set +x
-set -e` + "\n") // force newline
-	if err != nil {
-		d.err = err
-		return
-	}
-	for i := range d.commands {
-		line := fmt.Sprintf("%s %s\n", d.commands[i].command, strings.Join(d.commands[i].args, " "))
-		if _, err := script.WriteString(line); err != nil {
-			d.err = fmt.Errorf("command=%q err=%v", line, err)
+	for i := range directives {
+		if _, err := fmt.Fprintln(dst, directives[i].String()); err != nil {
+			d.err = fmt.Errorf("writing %s err=%v", directives[i].instruction, err)
 			return
 		}
 	}
-	d.err = script.Sync()
-	if d.err != nil {
+	if err := dst.Sync(); err != nil {
+		d.err = fmt.Errorf("dst fsync err=%v", err)
 		return
 	}
 
-	// Build docker image now
-	out, err := exec.Command("docker", "build", "-t", d.tag, dir).CombinedOutput()
-	if err != nil {
-		d.err = fmt.Errorf("ERROR: err=%v\nOutput: %s", err, string(out))
-	}
+	// Build the image now
+	d.err = d.builder.Build(dir, d.tag)
 }
 
 func (d *dockerfile) run() {
@@ -228,14 +226,12 @@ func (d *dockerfile) run() {
 		return
 	}
 
-	// build `docker run` flags
-	args := []string{"run"}
+	var env []string
 	if debug {
-		args = append(args, "-e", "DEBUG=true")
+		env = append(env, "DEBUG=true")
 	}
-	args = append(args, "-t", d.tag)
 
-	out, err := exec.Command("docker", args...).CombinedOutput()
+	out, err := d.builder.Run(d.tag, env)
 	if err != nil {
 		d.err = fmt.Errorf("ERROR: err=%v\nOutput: %s", err, string(out))
 	}
@@ -251,9 +247,19 @@ func (d *dockerfile) prep() {
 }
 
 func (d *dockerfile) enabled() bool {
+	if _, ok := d.builder.(dockerBuilder); !ok {
+		// Daemonless builders (TEST_BUILDER=buildah|kaniko) don't need a docker
+		// daemon at all, so skip the docker-specific enablement check.
+		return true
+	}
 	return IsDockerEnabled()
 }
 
+// inCI reports whether the suite appears to be running under a CI provider.
+func inCI() bool {
+	return os.Getenv("CI") != "" || os.Getenv("APPVEYOR") != "" || os.Getenv("TRAVIS") != ""
+}
+
 func IsDockerEnabled() bool {
 	if mocked {
 		return false
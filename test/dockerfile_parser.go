@@ -0,0 +1,133 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// directive is a single Dockerfile instruction - e.g. `RUN foo` becomes
+// directive{instruction: "RUN", args: "foo"}.
+type directive struct {
+	instruction string
+	args        string
+}
+
+func (d directive) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", d.instruction, d.args))
+}
+
+// dispatch maps each supported instruction to the function that validates its
+// arguments, patterned after the per-directive handlers in
+// openshift/imagebuilder. Anything outside this set is rejected rather than
+// silently passed through, since envs/*/Dockerfile is meant to stay simple.
+var dispatch = map[string]func(args string) error{
+	"FROM":       dispatchFrom,
+	"COPY":       dispatchCopy,
+	"RUN":        dispatchRun,
+	"ENV":        dispatchEnv,
+	"ENTRYPOINT": dispatchEntrypoint,
+}
+
+func dispatchFrom(args string) error {
+	if strings.TrimSpace(args) == "" {
+		return fmt.Errorf("FROM requires an image reference")
+	}
+	return nil
+}
+
+func dispatchCopy(args string) error {
+	if len(strings.Fields(args)) < 2 {
+		return fmt.Errorf("COPY requires a source and destination, got %q", args)
+	}
+	return nil
+}
+
+func dispatchRun(args string) error {
+	if strings.TrimSpace(args) == "" {
+		return fmt.Errorf("RUN requires a command")
+	}
+	return nil
+}
+
+func dispatchEnv(args string) error {
+	if strings.TrimSpace(args) == "" {
+		return fmt.Errorf("ENV requires a key and value")
+	}
+	return nil
+}
+
+func dispatchEntrypoint(args string) error {
+	if strings.TrimSpace(args) == "" {
+		return fmt.Errorf("ENTRYPOINT requires a command")
+	}
+	return nil
+}
+
+// parseDockerfile reads the Dockerfile at path into its directives, in file order,
+// so that tests can append their own RUN/COPY directives on top of it rather than
+// folding everything into one shell script. Blank lines and comments are dropped
+// and backslash line-continuations are joined before an instruction is dispatched.
+func parseDockerfile(path string) ([]directive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var directives []directive
+	var pending string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if pending != "" {
+			line = pending + " " + line
+			pending = ""
+		}
+		if strings.HasSuffix(line, "\\") {
+			pending = strings.TrimSpace(strings.TrimSuffix(line, "\\"))
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		instruction := strings.ToUpper(fields[0])
+		var args string
+		if len(fields) == 2 {
+			args = strings.TrimSpace(fields[1])
+		}
+
+		handler, ok := dispatch[instruction]
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported Dockerfile instruction", instruction)
+		}
+		if err := handler(args); err != nil {
+			return nil, fmt.Errorf("%s: %v", instruction, err)
+		}
+
+		directives = append(directives, directive{instruction: instruction, args: args})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return directives, nil
+}
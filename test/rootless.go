@@ -0,0 +1,201 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/adamdecaf/cert-manage/tools/file"
+)
+
+// rootlessBuilder satisfies Builder without talking to a container daemon. It
+// interprets the same directives dockerBuilder hands to `docker build`/`docker run`
+// itself: FROM unpacks a base image into a scratch rootfs, COPY lays build-context
+// files on top of it, RUN executes immediately (so a failing RUN fails Build, the
+// same as a failing layer fails `docker build`), and ENTRYPOINT is remembered for
+// Run to execute later. Everything runs under a chroot + fresh mount/PID namespace -
+// the same primitives `buildah run` and `runc` use - so there's no daemon, no docker
+// socket, and no contention between environments run in parallel.
+type rootlessBuilder struct {
+	// tool names which daemonless image tool produced the rootfs: "buildah" or "kaniko"
+	tool string
+
+	rootfs string
+
+	// env accumulates ENV directives so they're set for every RUN/ENTRYPOINT that
+	// follows, mirroring how docker threads ENV into later layers.
+	env []string
+
+	// entrypoint is the command named by an ENTRYPOINT directive, executed by Run.
+	entrypoint string
+}
+
+func newRootlessBuilder(tool string) Builder {
+	return &rootlessBuilder{tool: tool}
+}
+
+func (b *rootlessBuilder) Build(dir, tag string) error {
+	directives, err := parseDockerfile(filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		return err
+	}
+
+	rootfs, err := ioutil.TempDir("", "rootless-"+strings.Map(sanitizeTag, tag))
+	if err != nil {
+		return fmt.Errorf("rootless: tempdir err=%v", err)
+	}
+	b.rootfs = rootfs
+
+	for _, d := range directives {
+		switch d.instruction {
+		case "FROM":
+			if err := unpackImage(b.tool, d.args, rootfs); err != nil {
+				return err
+			}
+		case "ENV":
+			b.env = append(b.env, toEnvVar(d.args))
+		case "COPY":
+			if err := b.copy(dir, d.args); err != nil {
+				return err
+			}
+		case "RUN":
+			out, err := b.exec(d.args, nil)
+			if err != nil {
+				return fmt.Errorf("rootless: RUN %q: err=%v\nOutput: %s", d.args, err, string(out))
+			}
+		case "ENTRYPOINT":
+			b.entrypoint = d.args
+		default:
+			return fmt.Errorf("rootless: %s isn't supported outside of docker", d.instruction)
+		}
+	}
+
+	return nil
+}
+
+// copy lays a COPY directive's source (relative to the build context in dir) on top
+// of the rootfs at its destination, the same effect `docker build` gets by unpacking
+// that layer.
+func (b *rootlessBuilder) copy(dir, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		return fmt.Errorf("rootless: COPY %q: expected a source and destination", args)
+	}
+	src := filepath.Join(dir, fields[0])
+	dst := filepath.Join(b.rootfs, fields[1])
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("rootless: COPY %s: %v", args, err)
+	}
+	if err := file.CopyFile(src, dst); err != nil {
+		return fmt.Errorf("rootless: COPY %s: %v", args, err)
+	}
+	return nil
+}
+
+func (b *rootlessBuilder) Run(tag string, env []string) ([]byte, error) {
+	if b.rootfs == "" {
+		return nil, fmt.Errorf("rootless: %s was never built", tag)
+	}
+	if b.entrypoint == "" {
+		// Nothing named by ENTRYPOINT, same as `docker run` against an image with
+		// no CMD/ENTRYPOINT - there's nothing to execute.
+		return nil, nil
+	}
+	return b.exec(b.entrypoint, env)
+}
+
+// exec runs script through the image's shell inside the built rootfs, under a
+// chroot and fresh mount/PID/UTS namespace.
+func (b *rootlessBuilder) exec(script string, env []string) ([]byte, error) {
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Dir = "/"
+	cmd.Env = append(append([]string{}, b.env...), env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Chroot:     b.rootfs,
+		Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS,
+	}
+	return cmd.CombinedOutput()
+}
+
+// toEnvVar turns an ENV directive's args ("KEY value" or "KEY=value") into a
+// KEY=value string suitable for exec.Cmd.Env.
+func toEnvVar(args string) string {
+	if strings.Contains(args, "=") {
+		return args
+	}
+	return strings.Replace(strings.TrimSpace(args), " ", "=", 1)
+}
+
+// unpackImage pulls ref with the given daemonless tool and extracts its filesystem
+// layers into dst.
+func unpackImage(tool, ref, dst string) error {
+	switch tool {
+	case "kaniko":
+		// kaniko is an image *builder* - its executor has no mode for pulling and
+		// exporting the filesystem of an already-published image, so unpacking the
+		// base image falls to skopeo (pull the image into an OCI layout) and umoci
+		// (OCI layout -> rootfs), the same two purpose-built daemonless tools
+		// buildah's "from"/"mount" wrap for us in the default case below.
+		oci := dst + "-oci"
+		out, err := exec.Command("skopeo", "copy", "docker://"+ref, "oci:"+oci+":latest").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("skopeo copy %s: err=%v\nOutput: %s", ref, err, string(out))
+		}
+		defer os.RemoveAll(oci)
+
+		unpacked := dst + "-unpacked"
+		out, err = exec.Command("umoci", "unpack", "--rootless", "--image", oci+":latest", unpacked).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("umoci unpack %s: err=%v\nOutput: %s", ref, err, string(out))
+		}
+		defer os.RemoveAll(unpacked)
+
+		_, err = file.MirrorDir(filepath.Join(unpacked, "rootfs"), dst, file.MirrorOptions{Hardlinks: true})
+		return err
+	default: // buildah
+		out, err := exec.Command("buildah", "from", "--pull", ref).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("buildah from %s: err=%v\nOutput: %s", ref, err, string(out))
+		}
+		container := strings.TrimSpace(string(out))
+		defer exec.Command("buildah", "rm", container).Run()
+
+		out, err = exec.Command("buildah", "mount", container).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("buildah mount %s: err=%v\nOutput: %s", container, err, string(out))
+		}
+		mountpoint := strings.TrimSpace(string(out))
+		defer exec.Command("buildah", "umount", container).Run()
+
+		_, err = file.MirrorDir(mountpoint, dst, file.MirrorOptions{Hardlinks: true})
+		return err
+	}
+}
+
+func sanitizeTag(r rune) rune {
+	if r == ':' || r == '/' {
+		return '-'
+	}
+	return r
+}
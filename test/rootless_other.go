@@ -0,0 +1,38 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !linux
+
+package test
+
+import "fmt"
+
+// rootlessBuilder is only implemented on linux, where chroot(2) + unshare(2) give us
+// an unprivileged sandbox to execute a Dockerfile's directives in. Elsewhere
+// TEST_BUILDER=buildah/kaniko fails fast instead of silently falling back to docker.
+type rootlessBuilder struct {
+	tool string
+}
+
+func newRootlessBuilder(tool string) Builder {
+	return &rootlessBuilder{tool: tool}
+}
+
+func (b *rootlessBuilder) Build(dir, tag string) error {
+	return fmt.Errorf("TEST_BUILDER=%s is only supported on linux", b.tool)
+}
+
+func (b *rootlessBuilder) Run(tag string, env []string) ([]byte, error) {
+	return nil, fmt.Errorf("TEST_BUILDER=%s is only supported on linux", b.tool)
+}
@@ -1,6 +1,8 @@
 package file
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -20,11 +22,58 @@ func Exists(path string) bool {
 	return err == nil
 }
 
-// MirrorDir will take a `src` directory and mirror it exactly under `dst` location.
-// If no errors occur during the mirroring `nil` is returned, otherwise an non-nil error
+// MirrorOptions controls how MirrorDir treats ownership, extended attributes, and
+// duplicate content while mirroring a directory tree. The zero value reproduces
+// the historical behavior: every regular file is copied byte-for-byte.
+type MirrorOptions struct {
+	// PreserveOwner chowns each destination file/dir to match its source's owner.
+	PreserveOwner bool
+
+	// PreserveXattr copies extended attributes (e.g. SELinux labels) alongside content.
+	PreserveXattr bool
+
+	// Hardlinks re-links a file that shares an inode with one already mirrored in
+	// this run, instead of copying it a second time. This matters for stores like
+	// NSS's cert9.db companion files, where several paths can share one inode.
+	Hardlinks bool
+
+	// Dedup hardlinks a source file to an identical (by SHA-256) file already
+	// written under dst in this run, instead of copying it again. Useful when
+	// several trust store snapshots carry identical PEM blobs.
+	Dedup bool
+}
+
+// MirrorSummary reports how much work a MirrorDir call actually did, so callers
+// can log how much a Hardlinks/Dedup pass saved.
+type MirrorSummary struct {
+	BytesCopied int64
+	BytesLinked int64
+}
+
+// MirrorDir takes a `src` directory and mirrors it exactly under `dst`, honoring
+// opts along the way. Symlinks are always copied as symlinks.
 // Adapted From: https://gist.github.com/r0l1/92462b38df26839a3ca324697c8cba04
-// - Symlinks are copied
-func MirrorDir(src, dst string) error {
+func MirrorDir(src, dst string, opts MirrorOptions) (MirrorSummary, error) {
+	m := &mirror{
+		opts:    opts,
+		inodes:  make(map[inodeKey]string),
+		digests: make(map[string]string),
+	}
+	err := m.dir(src, dst)
+	return m.summary, err
+}
+
+// mirror carries the state a MirrorDir call accumulates across its recursive
+// descent: which inodes and content digests have already been written under dst,
+// and the running byte totals.
+type mirror struct {
+	opts    MirrorOptions
+	inodes  map[inodeKey]string // (dev, ino) -> dst path already written
+	digests map[string]string   // hex sha256 -> dst path already written
+	summary MirrorSummary
+}
+
+func (m *mirror) dir(src, dst string) error {
 	src = filepath.Clean(src)
 	dst = filepath.Clean(dst)
 
@@ -42,7 +91,12 @@ func MirrorDir(src, dst string) error {
 	if err != nil && !os.IsNotExist(err) {
 		return err // some fs error occurred
 	}
-	err = os.MkdirAll(dst, s.Mode()) // create `dst` with `src` perms
+	if err := os.MkdirAll(dst, s.Mode()); err != nil { // create `dst` with `src` perms
+		return err
+	}
+	if err := m.preserve(src, dst); err != nil {
+		return err
+	}
 
 	// copy files, symlinks, and dirs
 	items, err := ioutil.ReadDir(src)
@@ -54,8 +108,7 @@ func MirrorDir(src, dst string) error {
 		d := filepath.Join(dst, item.Name())
 
 		if item.IsDir() {
-			err = MirrorDir(s, d)
-			if err != nil {
+			if err := m.dir(s, d); err != nil {
 				return err
 			}
 			continue
@@ -68,21 +121,91 @@ func MirrorDir(src, dst string) error {
 			if err != nil {
 				return err
 			}
-			err = os.Symlink(final, d)
-			if err != nil {
+			if err := os.Symlink(final, d); err != nil {
 				return err
 			}
-		} else {
-			err = CopyFile(s, d)
-			if err != nil {
+			continue
+		}
+
+		if err := m.file(s, d, item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// file mirrors a single regular file, preferring a hardlink over a copy when
+// Hardlinks/Dedup let it.
+func (m *mirror) file(src, dst string, info os.FileInfo) error {
+	if m.opts.Hardlinks {
+		if key, ok := inodeKeyOf(info); ok {
+			if existing, seen := m.inodes[key]; seen {
+				if err := os.Link(existing, dst); err != nil {
+					return err
+				}
+				m.summary.BytesLinked += info.Size()
+				return nil
+			}
+			m.inodes[key] = dst
+		}
+	}
+
+	if m.opts.Dedup {
+		digest, err := sha256File(src)
+		if err != nil {
+			return err
+		}
+		if existing, ok := m.digests[digest]; ok {
+			if err := os.Link(existing, dst); err != nil {
 				return err
 			}
+			m.summary.BytesLinked += info.Size()
+			return nil
 		}
+		m.digests[digest] = dst
 	}
 
+	if err := CopyFile(src, dst); err != nil {
+		return err
+	}
+	if err := m.preserve(src, dst); err != nil {
+		return err
+	}
+	m.summary.BytesCopied += info.Size()
 	return nil
 }
 
+// preserve applies whichever of PreserveOwner/PreserveXattr are set to dst, based
+// on src's metadata.
+func (m *mirror) preserve(src, dst string) error {
+	if m.opts.PreserveOwner {
+		if err := chownLike(src, dst); err != nil {
+			return err
+		}
+	}
+	if m.opts.PreserveXattr {
+		if err := copyXattrs(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // CopyFile duplicates the contents of `src` and writes it to a file at `dst` with the same permissions
 // The parent dirs of `dst` are assumed to exist.
 // Adapted From: https://gist.github.com/r0l1/92462b38df26839a3ca324697c8cba04
@@ -120,4 +243,4 @@ func CopyFile(src, dst string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,101 @@
+// +build !windows
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func sameInode(t *testing.T, a, b string) bool {
+	t.Helper()
+	sa, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("stat %s: %v", a, err)
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("stat %s: %v", b, err)
+	}
+	ia, ok := sa.Sys().(*syscall.Stat_t)
+	if !ok {
+		t.Skip("inode info not available on this platform")
+	}
+	ib := sb.Sys().(*syscall.Stat_t)
+	return ia.Dev == ib.Dev && ia.Ino == ib.Ino
+}
+
+func TestMirrorDir_Hardlinks(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(src, "a.pem"), []byte("same bytes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "a.pem"), filepath.Join(src, "b.pem")); err != nil {
+		t.Skipf("hardlinks not supported in this environment: %v", err)
+	}
+
+	summary, err := MirrorDir(src, dst, MirrorOptions{Hardlinks: true})
+	if err != nil {
+		t.Fatalf("MirrorDir: %v", err)
+	}
+
+	if !sameInode(t, filepath.Join(dst, "a.pem"), filepath.Join(dst, "b.pem")) {
+		t.Error("mirrored files sharing a source inode should share a dest inode")
+	}
+	if summary.BytesLinked == 0 {
+		t.Error("summary should report the hardlinked bytes")
+	}
+}
+
+func TestMirrorDir_Dedup(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(src, "a.pem"), []byte("identical content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "b.pem"), []byte("identical content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := MirrorDir(src, dst, MirrorOptions{Dedup: true})
+	if err != nil {
+		t.Fatalf("MirrorDir: %v", err)
+	}
+
+	if !sameInode(t, filepath.Join(dst, "a.pem"), filepath.Join(dst, "b.pem")) {
+		t.Error("files with identical content should be deduped onto one dest inode")
+	}
+	if summary.BytesLinked == 0 {
+		t.Error("summary should report the deduped bytes")
+	}
+}
+
+func TestMirrorDir_NoOptions_CopiesEverything(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	if err := ioutil.WriteFile(filepath.Join(src, "a.pem"), []byte("identical content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "b.pem"), []byte("identical content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := MirrorDir(src, dst, MirrorOptions{})
+	if err != nil {
+		t.Fatalf("MirrorDir: %v", err)
+	}
+
+	if sameInode(t, filepath.Join(dst, "a.pem"), filepath.Join(dst, "b.pem")) {
+		t.Error("without Dedup, identical-content files shouldn't be linked together")
+	}
+	if summary.BytesLinked != 0 {
+		t.Errorf("got BytesLinked = %d, want 0 when no linking option is set", summary.BytesLinked)
+	}
+}
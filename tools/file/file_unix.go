@@ -0,0 +1,110 @@
+// +build !windows
+
+package file
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// inodeKey identifies a file by the (device, inode) pair its source carries, which
+// is how MirrorDir notices two paths are hardlinks of each other.
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+func inodeKeyOf(fi os.FileInfo) (inodeKey, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inodeKey{}, false
+	}
+	return inodeKey{dev: uint64(st.Dev), ino: st.Ino}, true
+}
+
+func chownLike(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return os.Chown(dst, int(st.Uid), int(st.Gid))
+}
+
+// xattrBufSize is the initial guess for a Listxattr/Getxattr buffer - comfortably
+// covers the SELinux/ACL-style xattrs cert stores use. When a call reports ERANGE
+// (the buffer was too small), getXattr/listXattrs double it and retry the call.
+const xattrBufSize = 4096
+
+// xattrMaxBufSize bounds how far getXattr/listXattrs grow the buffer before giving up, so a
+// pathological xattr can't spin the retry loop forever.
+const xattrMaxBufSize = 1 << 20
+
+func copyXattrs(src, dst string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		val, err := getXattr(src, name)
+		if err != nil {
+			return err
+		}
+		if err := unix.Setxattr(dst, name, val, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size := xattrBufSize
+	for {
+		buf := make([]byte, size)
+		n, err := unix.Getxattr(path, name, buf)
+		if err == nil {
+			return buf[:n], nil
+		}
+		if err == unix.ERANGE && size < xattrMaxBufSize {
+			size *= 2
+			continue
+		}
+		return nil, err
+	}
+}
+
+func listXattrs(path string) ([]string, error) {
+	size := xattrBufSize
+	for {
+		buf := make([]byte, size)
+		n, err := unix.Listxattr(path, buf)
+		if err == nil {
+			return splitXattrNames(buf[:n]), nil
+		}
+		if err == unix.ENOTSUP {
+			return nil, nil // filesystem doesn't support xattrs at all
+		}
+		if err == unix.ERANGE && size < xattrMaxBufSize {
+			size *= 2
+			continue
+		}
+		return nil, err
+	}
+}
+
+func splitXattrNames(buf []byte) []string {
+	var names []string
+	for _, part := range bytes.Split(buf, []byte{0}) {
+		if len(part) > 0 {
+			names = append(names, string(part))
+		}
+	}
+	return names
+}
@@ -0,0 +1,24 @@
+// +build windows
+
+package file
+
+import (
+	"fmt"
+	"os"
+)
+
+// inodeKey is unused on windows: NTFS file IDs aren't exposed through os.FileInfo
+// without an extra syscall, and Hardlinks isn't supported here yet.
+type inodeKey struct{}
+
+func inodeKeyOf(fi os.FileInfo) (inodeKey, bool) {
+	return inodeKey{}, false
+}
+
+func chownLike(src, dst string) error {
+	return fmt.Errorf("file: PreserveOwner isn't supported on windows")
+}
+
+func copyXattrs(src, dst string) error {
+	return fmt.Errorf("file: PreserveXattr isn't supported on windows")
+}
@@ -0,0 +1,130 @@
+package whitelist
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// fingerprint matches a certificate by the hex-encoded prefix of its raw signature,
+// the identifier the JSON whitelist format has always used under Signatures.Hex.
+type fingerprint struct {
+	Signature string
+}
+
+func (f fingerprint) Matches(cert x509.Certificate) bool {
+	if len(f.Signature) == 0 {
+		return false
+	}
+	return strings.HasPrefix(hex.EncodeToString(cert.Signature), strings.ToLower(f.Signature))
+}
+
+// fingerprintSHA256 matches a certificate by the SHA-256 digest of its raw DER bytes.
+type fingerprintSHA256 struct {
+	Signature string
+}
+
+func (f fingerprintSHA256) Matches(cert x509.Certificate) bool {
+	if len(f.Signature) == 0 {
+		return false
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return strings.EqualFold(hex.EncodeToString(sum[:]), f.Signature)
+}
+
+// fingerprintSHA1 matches a certificate by the SHA-1 digest of its raw DER bytes.
+type fingerprintSHA1 struct {
+	Signature string
+}
+
+func (f fingerprintSHA1) Matches(cert x509.Certificate) bool {
+	if len(f.Signature) == 0 {
+		return false
+	}
+	sum := sha1.Sum(cert.Raw)
+	return strings.EqualFold(hex.EncodeToString(sum[:]), f.Signature)
+}
+
+// spkiSHA256 matches a certificate by the SHA-256 digest of its SubjectPublicKeyInfo,
+// the same key-pinning identifier browsers/HPKP use so the pin survives a cert's
+// re-issuance as long as the key doesn't change.
+type spkiSHA256 struct {
+	Signature string
+}
+
+func (s spkiSHA256) Matches(cert x509.Certificate) bool {
+	if len(s.Signature) == 0 {
+		return false
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return strings.EqualFold(hex.EncodeToString(sum[:]), s.Signature)
+}
+
+// issuersCommonName matches a certificate whose issuer's CommonName contains Name.
+type issuersCommonName struct {
+	Name string
+}
+
+func (i issuersCommonName) Matches(cert x509.Certificate) bool {
+	if len(i.Name) == 0 {
+		return false
+	}
+	return strings.Contains(cert.Issuer.CommonName, i.Name)
+}
+
+// issuersOrganization matches a certificate whose issuer's Organization contains Name.
+type issuersOrganization struct {
+	Name string
+}
+
+func (i issuersOrganization) Matches(cert x509.Certificate) bool {
+	if len(i.Name) == 0 {
+		return false
+	}
+	for _, org := range cert.Issuer.Organization {
+		if strings.Contains(org, i.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// subjectRegex matches a certificate whose Subject (in its RDN string form)
+// satisfies a regular expression.
+type subjectRegex struct {
+	re *regexp.Regexp
+}
+
+func (s subjectRegex) Matches(cert x509.Certificate) bool {
+	if s.re == nil {
+		return false
+	}
+	return s.re.MatchString(cert.Subject.String())
+}
+
+// validityWindow flags a certificate whose NotAfter falls outside a policy horizon.
+// Leaving either bound zero makes it unbounded on that side. Unlike Item, a
+// validityWindow can't be OR'd in alongside ordinary whitelist entries: "expire
+// trust of certs valid past a horizon" has to remove a cert even if some other
+// item would otherwise match it, so it's a VetoItem instead.
+type validityWindow struct {
+	NotAfterBefore time.Time
+	NotAfterAfter  time.Time
+}
+
+// Violates reports whether cert's NotAfter falls outside the window this policy
+// horizon allows - either because the cert is trusted for longer than
+// NotAfterBefore permits, or because it expires earlier than NotAfterAfter requires.
+func (v validityWindow) Violates(cert x509.Certificate) bool {
+	if !v.NotAfterBefore.IsZero() && cert.NotAfter.After(v.NotAfterBefore) {
+		return true
+	}
+	if !v.NotAfterAfter.IsZero() && cert.NotAfter.Before(v.NotAfterAfter) {
+		return true
+	}
+	return false
+}
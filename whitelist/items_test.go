@@ -0,0 +1,205 @@
+package whitelist
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// newTestCert builds a minimal leaf certificate signed by a separate test CA,
+// optionally mutating the leaf template before signing, so each Item's Matches
+// logic can be exercised without a testdata fixture. It's signed by a distinct CA
+// (rather than self-signed) so cert.Issuer actually reflects the CA's Subject
+// instead of being silently overwritten with the leaf's own Subject.
+func newTestCert(t *testing.T, mutate func(*x509.Certificate)) *x509.Certificate {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Issuing CA", Organization: []string{"Test Org"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %v", err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	if mutate != nil {
+		mutate(tmpl)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	return cert
+}
+
+func TestFingerprint(t *testing.T) {
+	cert := newTestCert(t, nil)
+	sig := hex.EncodeToString(cert.Signature)
+
+	if (fingerprint{}).Matches(*cert) {
+		t.Error("empty fingerprint shouldn't match")
+	}
+	if !(fingerprint{Signature: sig[:8]}).Matches(*cert) {
+		t.Error("hex prefix of the signature should match")
+	}
+	if !(fingerprint{Signature: sig}).Matches(*cert) {
+		t.Error("full signature should match")
+	}
+	if (fingerprint{Signature: "zzzzzzzz"}).Matches(*cert) {
+		t.Error("unrelated signature shouldn't match")
+	}
+}
+
+func TestFingerprintSHA256(t *testing.T) {
+	cert := newTestCert(t, nil)
+	sum := sha256.Sum256(cert.Raw)
+	digest := hex.EncodeToString(sum[:])
+
+	if !(fingerprintSHA256{Signature: digest}).Matches(*cert) {
+		t.Error("correct SHA-256 digest should match")
+	}
+	if (fingerprintSHA256{}).Matches(*cert) {
+		t.Error("empty digest shouldn't match")
+	}
+	if (fingerprintSHA256{Signature: "00"}).Matches(*cert) {
+		t.Error("wrong digest shouldn't match")
+	}
+}
+
+func TestFingerprintSHA1(t *testing.T) {
+	cert := newTestCert(t, nil)
+	sum := sha1.Sum(cert.Raw)
+	digest := hex.EncodeToString(sum[:])
+
+	if !(fingerprintSHA1{Signature: digest}).Matches(*cert) {
+		t.Error("correct SHA-1 digest should match")
+	}
+	if (fingerprintSHA1{Signature: "00"}).Matches(*cert) {
+		t.Error("wrong digest shouldn't match")
+	}
+}
+
+func TestSPKISHA256(t *testing.T) {
+	cert := newTestCert(t, nil)
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	digest := hex.EncodeToString(sum[:])
+
+	if !(spkiSHA256{Signature: digest}).Matches(*cert) {
+		t.Error("correct SPKI digest should match")
+	}
+	if (spkiSHA256{Signature: "00"}).Matches(*cert) {
+		t.Error("wrong digest shouldn't match")
+	}
+}
+
+func TestIssuersCommonName(t *testing.T) {
+	cert := newTestCert(t, nil)
+
+	if !(issuersCommonName{Name: "Issuing"}).Matches(*cert) {
+		t.Error("substring of the issuer CommonName should match")
+	}
+	if (issuersCommonName{}).Matches(*cert) {
+		t.Error("empty name shouldn't match")
+	}
+	if (issuersCommonName{Name: "Nope"}).Matches(*cert) {
+		t.Error("unrelated name shouldn't match")
+	}
+}
+
+func TestIssuersOrganization(t *testing.T) {
+	cert := newTestCert(t, nil)
+
+	if !(issuersOrganization{Name: "Test Org"}).Matches(*cert) {
+		t.Error("issuer organization should match")
+	}
+	if (issuersOrganization{Name: "Nope"}).Matches(*cert) {
+		t.Error("unrelated organization shouldn't match")
+	}
+}
+
+func TestSubjectRegex(t *testing.T) {
+	cert := newTestCert(t, nil)
+	re := regexp.MustCompile(`CN=test\.example\.com`)
+
+	if !(subjectRegex{re: re}).Matches(*cert) {
+		t.Error("subject regex should match")
+	}
+	if (subjectRegex{}).Matches(*cert) {
+		t.Error("nil regexp shouldn't match")
+	}
+}
+
+func TestValidityWindow_Violates(t *testing.T) {
+	horizon := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := validityWindow{NotAfterBefore: horizon}
+
+	longLived := newTestCert(t, func(c *x509.Certificate) {
+		c.NotAfter = time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+	if !window.Violates(*longLived) {
+		t.Error("a cert trusted past the horizon should violate the policy")
+	}
+
+	ordinary := newTestCert(t, func(c *x509.Certificate) {
+		c.NotAfter = time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+	if window.Violates(*ordinary) {
+		t.Error("a cert expiring before the horizon shouldn't violate the policy")
+	}
+}
+
+func TestValidityWindow_NotAfterAfter(t *testing.T) {
+	floor := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := validityWindow{NotAfterAfter: floor}
+
+	tooShort := newTestCert(t, func(c *x509.Certificate) {
+		c.NotAfter = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+	if !window.Violates(*tooShort) {
+		t.Error("a cert expiring before the floor should violate the policy")
+	}
+
+	ordinary := newTestCert(t, func(c *x509.Certificate) {
+		c.NotAfter = time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+	if window.Violates(*ordinary) {
+		t.Error("a cert expiring after the floor shouldn't violate the policy")
+	}
+}
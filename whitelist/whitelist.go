@@ -5,19 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"regexp"
 	"strings"
-	// "time"
+	"time"
 
 	"github.com/adamdecaf/cert-manage/tools/file"
+	yaml "gopkg.in/yaml.v2"
 )
 
 // TOOD(adam): Read and review this code
 // https://blog.hboeck.de/archives/888-How-I-tricked-Symantec-with-a-Fake-Private-Key.html
 
-// todo: dedup certs already added by one whitelist item
-// e.g. If my []Item contains a signature and Issuer.CommonName match
-// don't add the cert twice
-
 // Item can be compared against an x509 Certificate to see if the cert represents
 // some value presented by the whitelist item. This is useful in comparing specific fields of
 // Certificate against multiple whitelist candidates.
@@ -25,24 +24,49 @@ type Item interface {
 	Matches(x509.Certificate) bool
 }
 
-// findRemovable a list of x509 Certificates against whitelist items to
+// VetoItem can be compared against an x509 Certificate to see if the cert breaks
+// some policy the item enforces. Unlike Item, a VetoItem can't safely be OR'd in
+// alongside the rest of a whitelist: "kept" has to lose to "violates policy"
+// regardless of what else matched, so vetoes are checked separately and short-circuit
+// Whitelist.Matches to false instead of adding another way for a cert to be kept.
+type VetoItem interface {
+	Violates(x509.Certificate) bool
+}
+
+// Whitelist bundles the Items and Vetoes parsed from a whitelist file so store
+// backends have a single value to carry around and match certificates against.
+type Whitelist struct {
+	Items  []Item
+	Vetoes []VetoItem
+}
+
+// Matches reports whether cert is kept by the whitelist: none of its Vetoes are
+// violated, and at least one of its Items matches.
+func (w Whitelist) Matches(cert x509.Certificate) bool {
+	for _, v := range w.Vetoes {
+		if v.Violates(cert) {
+			return false
+		}
+	}
+	for _, it := range w.Items {
+		if it.Matches(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// findRemovable checks a list of x509 Certificates against a whitelist to
 // retain only the certificates that are disallowed by our whitelist.
 // An empty slice of certificates is a possible (and valid) output.
-func findRemovable(incoming []*x509.Certificate, whitelisted []Item) []*x509.Certificate {
-	// Pretty bad search right now.
+func findRemovable(incoming []*x509.Certificate, whitelisted Whitelist) []*x509.Certificate {
 	var removable []*x509.Certificate
 
 	for _, inc := range incoming {
-		remove := true
-		// If the whitelist matches on something then don't remove it
-		for _, wh := range whitelisted {
-			if inc != nil && wh.Matches(*inc) {
-				remove = false
-			}
-		}
-		if remove {
-			removable = append(removable, inc)
+		if inc != nil && whitelisted.Matches(*inc) {
+			continue
 		}
+		removable = append(removable, inc)
 	}
 
 	return removable
@@ -56,31 +80,153 @@ type jsonSignatures struct {
 	Hex []string `json:"Hex"`
 }
 
-// loadFromFile reads a whitelist file and parses it into Items
-func loadFromFile(path string) ([]Item, error) {
+// Yaml structure in struct form. It's a superset of what the JSON format can express:
+// SPKI pins, issuer matches, subject regexes and a validity-window policy horizon.
+type yamlWhitelist struct {
+	Fingerprints struct {
+		SHA256 []string `yaml:"sha256"`
+		SHA1   []string `yaml:"sha1"`
+	} `yaml:"fingerprints"`
+	SPKI struct {
+		SHA256 []string `yaml:"sha256"`
+	} `yaml:"spki"`
+	Issuers struct {
+		CommonName   []string `yaml:"common_name"`
+		Organization []string `yaml:"organization"`
+	} `yaml:"issuers"`
+	Subjects struct {
+		Regex []string `yaml:"regex"`
+	} `yaml:"subjects"`
+	Validity struct {
+		NotAfterBefore string `yaml:"not_after_before"`
+		NotAfterAfter  string `yaml:"not_after_after"`
+	} `yaml:"validity"`
+}
+
+// loadFromFile reads a whitelist file and parses it into a Whitelist. The format is
+// picked by the file's extension: `.yaml`/`.yml` get the richer yamlWhitelist schema,
+// everything else is parsed as the legacy jsonWhitelist schema.
+func loadFromFile(path string) (Whitelist, error) {
 	if !validWhitelistPath(path) {
-		return nil, fmt.Errorf("The path '%s' doesn't seem to contain a whitelist.", path)
+		return Whitelist{}, fmt.Errorf("The path '%s' doesn't seem to contain a whitelist.", path)
 	}
 
 	// read file
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return Whitelist{}, err
 	}
 
-	var parsed jsonWhitelist
-	err = json.Unmarshal(b, &parsed)
+	var wh Whitelist
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		wh, err = parseYAMLWhitelist(b)
+	default:
+		wh, err = parseJSONWhitelist(b)
+	}
 	if err != nil {
-		return nil, err
+		return Whitelist{}, err
 	}
 
-	// Read parsed format into structs
-	var items []Item
+	wh.Items = dedupeItems(wh.Items)
+	return wh, nil
+}
+
+func parseJSONWhitelist(b []byte) (Whitelist, error) {
+	var parsed jsonWhitelist
+	if err := json.Unmarshal(b, &parsed); err != nil {
+		return Whitelist{}, err
+	}
+
+	var wh Whitelist
 	for _, s := range parsed.Signatures.Hex {
-		items = append(items, fingerprint{Signature: s})
+		wh.Items = append(wh.Items, fingerprint{Signature: s})
+	}
+	return wh, nil
+}
+
+func parseYAMLWhitelist(b []byte) (Whitelist, error) {
+	var parsed yamlWhitelist
+	if err := yaml.Unmarshal(b, &parsed); err != nil {
+		return Whitelist{}, err
+	}
+
+	var wh Whitelist
+	for _, s := range parsed.Fingerprints.SHA256 {
+		wh.Items = append(wh.Items, fingerprintSHA256{Signature: s})
+	}
+	for _, s := range parsed.Fingerprints.SHA1 {
+		wh.Items = append(wh.Items, fingerprintSHA1{Signature: s})
+	}
+	for _, s := range parsed.SPKI.SHA256 {
+		wh.Items = append(wh.Items, spkiSHA256{Signature: s})
+	}
+	for _, s := range parsed.Issuers.CommonName {
+		wh.Items = append(wh.Items, issuersCommonName{Name: s})
+	}
+	for _, s := range parsed.Issuers.Organization {
+		wh.Items = append(wh.Items, issuersOrganization{Name: s})
+	}
+	for _, s := range parsed.Subjects.Regex {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return Whitelist{}, fmt.Errorf("subjects.regex %q: %v", s, err)
+		}
+		wh.Items = append(wh.Items, subjectRegex{re: re})
 	}
 
-	return items, nil
+	var window validityWindow
+	var hasWindow bool
+	if parsed.Validity.NotAfterBefore != "" {
+		t, err := parseValidityBound(parsed.Validity.NotAfterBefore)
+		if err != nil {
+			return Whitelist{}, fmt.Errorf("validity.not_after_before %q: %v", parsed.Validity.NotAfterBefore, err)
+		}
+		window.NotAfterBefore = t
+		hasWindow = true
+	}
+	if parsed.Validity.NotAfterAfter != "" {
+		t, err := parseValidityBound(parsed.Validity.NotAfterAfter)
+		if err != nil {
+			return Whitelist{}, fmt.Errorf("validity.not_after_after %q: %v", parsed.Validity.NotAfterAfter, err)
+		}
+		window.NotAfterAfter = t
+		hasWindow = true
+	}
+	if hasWindow {
+		wh.Vetoes = append(wh.Vetoes, window)
+	}
+
+	return wh, nil
+}
+
+// parseValidityBound accepts either a full RFC3339 timestamp or a bare date, since a
+// policy horizon is usually expressed as a day, not a moment.
+func parseValidityBound(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// dedupeItems drops whitelist items that are identical in both kind and value (e.g.
+// the same SPKI pin listed twice) so a cert satisfying more than one spec for the
+// same underlying value isn't matched, and thus isn't retained, more than once.
+func dedupeItems(items []Item) []Item {
+	seen := make(map[string]bool, len(items))
+	out := make([]Item, 0, len(items))
+	for _, it := range items {
+		key := fmt.Sprintf("%T:%+v", it, it)
+		if r, ok := it.(subjectRegex); ok && r.re != nil {
+			key = fmt.Sprintf("%T:%s", it, r.re.String()) // compare patterns, not *Regexp addresses
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, it)
+	}
+	return out
 }
 
 // validWhitelistPath verifies that the given whitelist filepath is properly defined
@@ -0,0 +1,118 @@
+package whitelist
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestParseYAMLWhitelist(t *testing.T) {
+	doc := []byte(`
+fingerprints:
+  sha256:
+    - "aaaa"
+  sha1:
+    - "bbbb"
+spki:
+  sha256:
+    - "cccc"
+issuers:
+  common_name:
+    - "Test CA"
+  organization:
+    - "Test Org"
+subjects:
+  regex:
+    - "CN=.*\\.example\\.com"
+validity:
+  not_after_before: "2030-01-01"
+`)
+
+	wh, err := parseYAMLWhitelist(doc)
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	if len(wh.Items) != 6 {
+		t.Errorf("got %d items, want 6", len(wh.Items))
+	}
+	if len(wh.Vetoes) != 1 {
+		t.Fatalf("got %d vetoes, want 1", len(wh.Vetoes))
+	}
+
+	window, ok := wh.Vetoes[0].(validityWindow)
+	if !ok {
+		t.Fatalf("veto has type %T, want validityWindow", wh.Vetoes[0])
+	}
+	want := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !window.NotAfterBefore.Equal(want) {
+		t.Errorf("NotAfterBefore = %v, want %v", window.NotAfterBefore, want)
+	}
+}
+
+func TestParseYAMLWhitelist_BadRegex(t *testing.T) {
+	doc := []byte(`
+subjects:
+  regex:
+    - "("
+`)
+	if _, err := parseYAMLWhitelist(doc); err == nil {
+		t.Error("expected an error parsing an invalid regex")
+	}
+}
+
+func TestParseJSONWhitelist(t *testing.T) {
+	doc := []byte(`{"Signatures":{"Hex":["aabb","ccdd"]}}`)
+
+	wh, err := parseJSONWhitelist(doc)
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	if len(wh.Items) != 2 {
+		t.Errorf("got %d items, want 2", len(wh.Items))
+	}
+}
+
+func TestDedupeItems(t *testing.T) {
+	items := []Item{
+		fingerprint{Signature: "aabb"},
+		fingerprint{Signature: "aabb"},
+		fingerprint{Signature: "ccdd"},
+	}
+	out := dedupeItems(items)
+	if len(out) != 2 {
+		t.Fatalf("got %d items after dedupe, want 2", len(out))
+	}
+}
+
+// TestWhitelist_VetoOverridesItems is a regression test for the bug where a
+// validityWindow (or any veto) composed as an ordinary OR'd Item and granted
+// blanket immunity instead of narrowly flagging certs that violate it: a cert kept
+// by a plain Item must still be removed if a veto is violated.
+func TestWhitelist_VetoOverridesItems(t *testing.T) {
+	cert := newTestCert(t, func(c *x509.Certificate) {
+		c.NotAfter = time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	wh := Whitelist{
+		Items:  []Item{issuersCommonName{Name: "Issuing"}}, // would otherwise keep the cert
+		Vetoes: []VetoItem{validityWindow{NotAfterBefore: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+
+	if wh.Matches(*cert) {
+		t.Error("a veto violation should remove the cert even though an Item matches it")
+	}
+
+	removable := findRemovable([]*x509.Certificate{cert}, wh)
+	if len(removable) != 1 {
+		t.Fatalf("got %d removable certs, want 1", len(removable))
+	}
+}
+
+func TestFindRemovable_KeepsMatchedCert(t *testing.T) {
+	cert := newTestCert(t, nil)
+	wh := Whitelist{Items: []Item{issuersCommonName{Name: "Issuing"}}}
+
+	if removable := findRemovable([]*x509.Certificate{cert}, wh); len(removable) != 0 {
+		t.Errorf("got %d removable certs, want 0 (cert is whitelisted)", len(removable))
+	}
+}